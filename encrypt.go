@@ -0,0 +1,209 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	secrand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrType1NotSupported is returned by EncryptPrivateKeyString for a Type1 identity.
+// The ztenc1 blob format only seals the legacy curve25519/ed25519 private key, so
+// sealing a Type1 identity would silently drop its P-384 private key; callers must
+// wait for that format to be extended, or encrypt the two key materials separately.
+var ErrType1NotSupported = errors.New("ztidentity: EncryptPrivateKeyString does not support Type1 identities yet")
+
+// ScryptParams tunes the scrypt key-stretching cost used by EncryptPrivateKeyString.
+type ScryptParams struct {
+	N, R, P int
+}
+
+// DefaultScryptParams are the cost parameters used when the zero value of ScryptParams
+// is passed to EncryptPrivateKeyString.
+var DefaultScryptParams = ScryptParams{N: 1 << 15, R: 8, P: 1}
+
+const encryptedIdentityPrefix = "ztenc1"
+
+// EncryptPrivateKeyString seals a Type0 identity's private key under a passphrase,
+// producing a self-describing identity.secret-like string of the form:
+//
+//	ztenc1:<hex-address>:<hex-salt>:<N>:<r>:<p>:<hex-nonce>:<hex-ciphertext-tag>
+//
+// The passphrase is stretched via scrypt into a 32-byte key, which seals the 64-byte
+// private key with XChaCha20-Poly1305. The address is carried alongside the ciphertext
+// as additional authenticated data, so the address field cannot be swapped onto a
+// ciphertext sealed for a different identity without the AEAD tag failing to verify.
+// It returns ErrType1NotSupported for a Type1 identity, since this blob format has no
+// room for the additional P-384 private key.
+func (id *ZeroTierIdentity) EncryptPrivateKeyString(passphrase []byte, params ScryptParams) (string, error) {
+	if id.privateKey == nil {
+		return "", ErrNoPrivateKey
+	}
+	if id.idType == Type1 {
+		return "", ErrType1NotSupported
+	}
+	if params == (ScryptParams{}) {
+		params = DefaultScryptParams
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(secrand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return "", err
+	}
+	defer scrub(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(secrand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	addrBytes := addressBytes(id.address)
+	ciphertext := aead.Seal(nil, nonce, id.privateKey[:], addrBytes[:])
+
+	return fmt.Sprintf("%s:%x:%x:%d:%d:%d:%x:%x", encryptedIdentityPrefix, addrBytes[:], salt, params.N, params.R, params.P, nonce, ciphertext), nil
+}
+
+// DecryptPrivateKey reverses EncryptPrivateKeyString, deriving the scrypt key from
+// passphrase and the embedded salt/cost parameters, opening the sealed private key,
+// and reconstructing the Type0 public key from it. The returned identity's address is
+// the one carried in the blob.
+func DecryptPrivateKey(blob string, passphrase []byte) (*ZeroTierIdentity, error) {
+	parts := strings.Split(blob, ":")
+	if len(parts) != 8 || parts[0] != encryptedIdentityPrefix {
+		return nil, fmt.Errorf("ztidentity: malformed encrypted identity blob")
+	}
+
+	addrBytes, err := hex.DecodeString(parts[1])
+	if err != nil || len(addrBytes) != 5 {
+		return nil, fmt.Errorf("ztidentity: malformed address in encrypted identity blob")
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed salt in encrypted identity blob: %w", err)
+	}
+	n, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed scrypt N in encrypted identity blob: %w", err)
+	}
+	r, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed scrypt r in encrypted identity blob: %w", err)
+	}
+	p, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed scrypt p in encrypted identity blob: %w", err)
+	}
+	nonce, err := hex.DecodeString(parts[6])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed nonce in encrypted identity blob: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(parts[7])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: malformed ciphertext in encrypted identity blob: %w", err)
+	}
+
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer scrub(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, addrBytes)
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: decryption failed (wrong passphrase or corrupt blob): %w", err)
+	}
+	if len(plaintext) != 64 {
+		return nil, fmt.Errorf("ztidentity: decrypted private key has length %d, want 64", len(plaintext))
+	}
+	var priv [64]byte
+	copy(priv[:], plaintext)
+
+	var curvePriv, curvePub [32]byte
+	copy(curvePriv[:], priv[0:32])
+	curve25519.ScalarBaseMult(&curvePub, &curvePriv)
+
+	edPriv := ed25519.NewKeyFromSeed(priv[32:64])
+	edPub := edPriv.Public().(ed25519.PublicKey)
+
+	var pub [64]byte
+	copy(pub[0:32], curvePub[:])
+	copy(pub[32:64], edPub)
+
+	var addr uint64
+	for _, b := range addrBytes {
+		addr <<= 8
+		addr |= uint64(b)
+	}
+
+	return &ZeroTierIdentity{address: addr, publicKey: pub, privateKey: &priv}, nil
+}
+
+// scrub zeroes b in place. The runtime.KeepAlive call prevents the compiler from
+// eliding the zeroing as a dead store once b is no longer read.
+func scrub(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
+
+func addressBytes(address uint64) [5]byte {
+	var out [5]byte
+	for i := 4; i >= 0; i-- {
+		out[i] = byte(address)
+		address >>= 8
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	"errors"
+	"testing"
+)
+
+// fastScryptParams keeps the test suite quick; production callers should rely on
+// DefaultScryptParams.
+var fastScryptParams = ScryptParams{N: 1 << 10, R: 8, P: 1}
+
+func TestEncryptDecryptPrivateKeyRoundTrip(t *testing.T) {
+	id := NewZeroTierIdentity()
+	passphrase := []byte("correct horse battery staple")
+
+	blob, err := id.EncryptPrivateKeyString(passphrase, fastScryptParams)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKeyString: %v", err)
+	}
+
+	decrypted, err := DecryptPrivateKey(blob, passphrase)
+	if err != nil {
+		t.Fatalf("DecryptPrivateKey: %v", err)
+	}
+	if decrypted.PrivateKeyString() != id.PrivateKeyString() {
+		t.Fatal("decrypted identity does not match original")
+	}
+}
+
+func TestDecryptPrivateKeyRejectsWrongPassphrase(t *testing.T) {
+	id := NewZeroTierIdentity()
+
+	blob, err := id.EncryptPrivateKeyString([]byte("correct horse battery staple"), fastScryptParams)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKeyString: %v", err)
+	}
+
+	if _, err := DecryptPrivateKey(blob, []byte("wrong passphrase")); err == nil {
+		t.Fatal("expected an error decrypting with the wrong passphrase")
+	}
+}
+
+func TestEncryptPrivateKeyStringRejectsType1(t *testing.T) {
+	id := NewZeroTierIdentityV1()
+
+	if _, err := id.EncryptPrivateKeyString([]byte("correct horse battery staple"), fastScryptParams); !errors.Is(err, ErrType1NotSupported) {
+		t.Fatalf("EncryptPrivateKeyString error = %v, want ErrType1NotSupported", err)
+	}
+}
@@ -95,11 +95,48 @@ func generateDualPair() (pub [64]byte, priv [64]byte) {
 	return
 }
 
+// IdentityType distinguishes the legacy curve25519/ed25519 identity format from the
+// newer format that additionally carries a NIST P-384 keypair.
+type IdentityType int
+
+const (
+	// Type0 is the legacy identity format: a curve25519 ECDH key, an ed25519 signing
+	// key, and an address derived from computeZeroTierIdentityMemoryHardHash.
+	Type0 IdentityType = iota
+
+	// Type1 is the newer identity format: a Type0 keypair plus a NIST P-384
+	// ECDH+ECDSA keypair, with the address derived from a SHA-384 hash of both
+	// public keys instead of the memory-hard hash. See NewZeroTierIdentityV1.
+	Type1
+)
+
+// String returns "0" or "1", matching the type field of the colon-separated identity
+// string forms.
+func (t IdentityType) String() string {
+	if t == Type1 {
+		return "1"
+	}
+	return "0"
+}
+
 // ZeroTierIdentity contains a public key, a private key, and a string representation of the identity.
 type ZeroTierIdentity struct {
 	address    uint64 // ZeroTier address, only least significant 40 bits are used
+	idType     IdentityType
 	publicKey  [64]byte
 	privateKey *[64]byte
+
+	// p384PublicKey and p384PrivateKey hold the additional NIST P-384 keypair carried
+	// by Type1 identities. Both are nil for Type0 identities. p384PublicKey is an
+	// uncompressed elliptic.Marshal point (97 bytes for P-384); p384PrivateKey is the
+	// 48-byte scalar.
+	p384PublicKey  []byte
+	p384PrivateKey []byte
+}
+
+// Type returns the identity's format: Type0 or Type1.
+func (id *ZeroTierIdentity) Type() IdentityType {
+	return id.idType
 }
 
 // NewZeroTierIdentity creates a new ZeroTier Identity.
@@ -130,14 +167,20 @@ func NewZeroTierIdentity() (id ZeroTierIdentity) {
 
 // PrivateKeyString returns the full identity.secret if the private key is set, or an empty string if no private key is set.
 func (id *ZeroTierIdentity) PrivateKeyString() string {
-	if id.privateKey != nil {
-		return fmt.Sprintf("%.10x:0:%x:%x", id.address, id.publicKey, *id.privateKey)
+	if id.privateKey == nil {
+		return ""
 	}
-	return ""
+	if id.idType == Type1 {
+		return fmt.Sprintf("%.10x:1:%x:%x:%x:%x", id.address, id.publicKey, id.p384PublicKey, *id.privateKey, id.p384PrivateKey)
+	}
+	return fmt.Sprintf("%.10x:0:%x:%x", id.address, id.publicKey, *id.privateKey)
 }
 
 // PublicKeyString returns identity.public contents.
 func (id *ZeroTierIdentity) PublicKeyString() string {
+	if id.idType == Type1 {
+		return fmt.Sprintf("%.10x:1:%x:%x", id.address, id.publicKey, id.p384PublicKey)
+	}
 	return fmt.Sprintf("%.10x:0:%x", id.address, id.publicKey)
 }
 
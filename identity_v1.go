@@ -0,0 +1,106 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	secrand "crypto/rand"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"fmt"
+)
+
+// ztIdentityV1HashCashFirstByteLessThan is the Type1 analogue of
+// ztIdentityHashCashFirstByteLessThan: a candidate keypair is accepted only if the
+// first byte of its SHA-384 address hash is less than this value.
+const ztIdentityV1HashCashFirstByteLessThan = 17
+
+// p384PublicKeySize is the length in bytes of an uncompressed elliptic.Marshal point
+// on P-384 (1 type byte + two 48-byte field elements).
+const p384PublicKeySize = 97
+
+// p384PrivateKeySize is the length in bytes of a P-384 scalar.
+const p384PrivateKeySize = 48
+
+// computeZeroTierIdentityV1AddressHash derives a Type1 identity's address from its
+// public key material via SHA-384, rather than the memory-hard hash used by Type0.
+func computeZeroTierIdentityV1AddressHash(c25519AndEd25519Pub [64]byte, p384Pub []byte) [48]byte {
+	data := make([]byte, 0, len(c25519AndEd25519Pub)+len(p384Pub))
+	data = append(data, c25519AndEd25519Pub[:]...)
+	data = append(data, p384Pub...)
+	return sha512.Sum384(data)
+}
+
+// NewZeroTierIdentityV1 creates a new Type1 ZeroTier identity: a Type0 curve25519 +
+// ed25519 keypair plus a NIST P-384 ECDH+ECDSA keypair, with the address derived from
+// a SHA-384 hash of both public keys instead of the memory-hard hash used by Type0.
+// Like NewZeroTierIdentity, this loops generating candidate keypairs until one
+// satisfies the hashcash-style proof-of-work constraint, so it can take a little time.
+func NewZeroTierIdentityV1() (id ZeroTierIdentity) {
+	id.idType = Type1
+	for {
+		pub, priv := generateDualPair()
+
+		p384Priv, err := ecdsa.GenerateKey(elliptic.P384(), secrand.Reader)
+		if err != nil {
+			panic(fmt.Sprintf("Not enough entropy: %v", err)) // FIXME for now; will adjust prototypes later
+		}
+		p384Pub := elliptic.Marshal(elliptic.P384(), p384Priv.X, p384Priv.Y)
+
+		dig := computeZeroTierIdentityV1AddressHash(pub, p384Pub)
+		if dig[0] < ztIdentityV1HashCashFirstByteLessThan && dig[43] != 0xff {
+			var addr uint64
+			for i := 43; i < 48; i++ {
+				addr <<= 8
+				addr |= uint64(dig[i])
+			}
+			if addr != 0 {
+				id.address = addr
+				id.publicKey = pub
+				id.privateKey = &priv
+				id.p384PublicKey = p384Pub
+				id.p384PrivateKey = p384Priv.D.FillBytes(make([]byte, p384PrivateKeySize))
+				break
+			}
+		}
+	}
+	return
+}
+
+// P384PublicKey returns the uncompressed P-384 public key point for a Type1 identity,
+// or nil for a Type0 identity.
+func (id *ZeroTierIdentity) P384PublicKey() []byte {
+	return id.p384PublicKey
+}
+
+// P384PrivateKey returns the P-384 private scalar for a Type1 identity if the private
+// key is set, or nil otherwise.
+func (id *ZeroTierIdentity) P384PrivateKey() []byte {
+	return id.p384PrivateKey
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MineStats reports progress of an in-flight NewZeroTierIdentityContext call.
+type MineStats struct {
+	Attempts uint64
+	Elapsed  time.Duration
+}
+
+// MineOptions configures NewZeroTierIdentityContext.
+type MineOptions struct {
+	// Workers is the number of goroutines searching for a valid identity in parallel.
+	// Defaults to runtime.NumCPU() if zero or negative.
+	Workers int
+
+	// Progress, if non-nil, receives periodic MineStats updates while mining is in
+	// progress. Sends are non-blocking, so a slow or absent receiver never stalls the
+	// miner.
+	Progress chan<- MineStats
+
+	// Difficulty overrides ztIdentityHashCashFirstByteLessThan: a candidate is accepted
+	// only if the first byte of its memory-hard hash is less than this value. Defaults
+	// to ztIdentityHashCashFirstByteLessThan if zero.
+	Difficulty byte
+}
+
+const mineProgressInterval = 200 * time.Millisecond
+
+// NewZeroTierIdentityContext mines a new ZeroTierIdentity the same way as
+// NewZeroTierIdentity, but fans the search out across opts.Workers goroutines and
+// stops as soon as ctx is cancelled or any worker finds a valid identity, cancelling
+// the rest. This turns the serial, hundreds-of-milliseconds mining loop into a
+// near-linear speedup on multi-core hosts and lets long-running searches (e.g. for a
+// higher-difficulty vanity address) be aborted cleanly.
+func NewZeroTierIdentityContext(ctx context.Context, opts MineOptions) (*ZeroTierIdentity, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	difficulty := opts.Difficulty
+	if difficulty == 0 {
+		difficulty = ztIdentityHashCashFirstByteLessThan
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		id  ZeroTierIdentity
+		err error
+	}
+	results := make(chan outcome, workers)
+
+	var attempts uint64
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			id, err := mineOne(ctx, difficulty, &attempts)
+			results <- outcome{id: id, err: err}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	if opts.Progress != nil {
+		progressDone := make(chan struct{})
+		defer func() { <-progressDone }()
+		go func() {
+			defer close(progressDone)
+			ticker := time.NewTicker(mineProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					stats := MineStats{Attempts: atomic.LoadUint64(&attempts), Elapsed: time.Since(start)}
+					select {
+					case opts.Progress <- stats:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	for r := range results {
+		if r.err == nil {
+			cancel()
+			return &r.id, nil
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// mineOne runs the generate/hash/check loop used by NewZeroTierIdentity against its
+// own crypto/rand stream until it finds a valid identity or ctx is cancelled.
+func mineOne(ctx context.Context, difficulty byte, attempts *uint64) (ZeroTierIdentity, error) {
+	var id ZeroTierIdentity
+	for {
+		select {
+		case <-ctx.Done():
+			return id, ctx.Err()
+		default:
+		}
+
+		pub, priv := generateDualPair()
+		atomic.AddUint64(attempts, 1)
+		dig := computeZeroTierIdentityMemoryHardHash(pub[:])
+		if dig[0] < difficulty && dig[59] != 0xff {
+			var addr uint64
+			for i := 59; i <= 63; i++ {
+				addr <<= 8
+				addr |= uint64(dig[i])
+			}
+			if addr != 0 {
+				id.address = addr
+				id.publicKey = pub
+				id.privateKey = &priv
+				return id, nil
+			}
+		}
+	}
+}
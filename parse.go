@@ -0,0 +1,415 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	ztAddressHexLen    = 10
+	ztPublicKeyHexLen  = 128
+	ztPrivateKeyHexLen = 128
+)
+
+// HexDecodeError is returned by ParseZeroTierIdentity when a field of the identity
+// string is not valid hex.
+type HexDecodeError struct {
+	Field string
+	Err   error
+}
+
+func (e *HexDecodeError) Error() string {
+	return fmt.Sprintf("ztidentity: invalid hex in %s: %v", e.Field, e.Err)
+}
+
+func (e *HexDecodeError) Unwrap() error { return e.Err }
+
+// LengthError is returned by ParseZeroTierIdentity when a field decodes to the wrong
+// number of bytes.
+type LengthError struct {
+	Field string
+	Got   int
+	Want  int
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("ztidentity: %s has length %d, want %d", e.Field, e.Got, e.Want)
+}
+
+// ProofOfWorkError is returned by ParseZeroTierIdentity when the public key does not
+// satisfy the hashcash-style proof-of-work constraint required of a valid identity.
+type ProofOfWorkError struct{}
+
+func (e *ProofOfWorkError) Error() string {
+	return "ztidentity: public key fails proof-of-work constraint"
+}
+
+// AddressMismatchError is returned by ParseZeroTierIdentity when the address encoded
+// in the string does not match the address derived from the public key.
+type AddressMismatchError struct {
+	Encoded, Derived uint64
+}
+
+func (e *AddressMismatchError) Error() string {
+	return fmt.Sprintf("ztidentity: encoded address %.10x does not match address %.10x derived from public key", e.Encoded, e.Derived)
+}
+
+// KeyDerivationError is returned by ParseZeroTierIdentity when a private key is
+// present but does not derive the accompanying public key.
+type KeyDerivationError struct{}
+
+func (e *KeyDerivationError) Error() string {
+	return "ztidentity: private key does not derive public key"
+}
+
+// ParseZeroTierIdentity parses the address:0:pub / address:0:pub:priv (Type0) or
+// address:1:c25519pub:p384pub / address:1:c25519pub:p384pub:c25519priv:p384priv
+// (Type1) forms (as found in identity.public / identity.secret files) into a
+// *ZeroTierIdentity, dispatching on the type field. It re-runs the proof-of-work check
+// for the identity's type on the public key and, if a private key is present,
+// verifies that it actually derives the public key, so a successfully parsed identity
+// is known to be internally consistent rather than merely well-formed.
+func ParseZeroTierIdentity(s string) (*ZeroTierIdentity, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("ztidentity: malformed identity string: want at least 3 colon-separated fields, got %d", len(parts))
+	}
+
+	switch parts[1] {
+	case "0":
+		return parseType0Identity(parts)
+	case "1":
+		return parseType1Identity(parts)
+	default:
+		return nil, fmt.Errorf("ztidentity: unsupported identity type %q", parts[1])
+	}
+}
+
+func parseType0Identity(parts []string) (*ZeroTierIdentity, error) {
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("ztidentity: malformed type-0 identity string: want 3 or 4 colon-separated fields, got %d", len(parts))
+	}
+
+	addressBytes, err := decodeHexField("address", parts[0], ztAddressHexLen/2)
+	if err != nil {
+		return nil, err
+	}
+	pubBytes, err := decodeHexField("public key", parts[2], ztPublicKeyHexLen/2)
+	if err != nil {
+		return nil, err
+	}
+
+	var id ZeroTierIdentity
+	for _, b := range addressBytes {
+		id.address <<= 8
+		id.address |= uint64(b)
+	}
+	copy(id.publicKey[:], pubBytes)
+
+	dig := computeZeroTierIdentityMemoryHardHash(id.publicKey[:])
+	if dig[0] >= ztIdentityHashCashFirstByteLessThan {
+		return nil, &ProofOfWorkError{}
+	}
+	var derivedAddress uint64
+	for i := 59; i <= 63; i++ {
+		derivedAddress <<= 8
+		derivedAddress |= uint64(dig[i])
+	}
+	if derivedAddress != id.address {
+		return nil, &AddressMismatchError{Encoded: id.address, Derived: derivedAddress}
+	}
+
+	if len(parts) == 4 {
+		privBytes, err := decodeHexField("private key", parts[3], ztPrivateKeyHexLen/2)
+		if err != nil {
+			return nil, err
+		}
+		var priv [64]byte
+		copy(priv[:], privBytes)
+
+		if err := checkDualPairDerivesPublicKey(priv, id.publicKey); err != nil {
+			return nil, err
+		}
+
+		id.privateKey = &priv
+	}
+
+	return &id, nil
+}
+
+func parseType1Identity(parts []string) (*ZeroTierIdentity, error) {
+	if len(parts) != 4 && len(parts) != 6 {
+		return nil, fmt.Errorf("ztidentity: malformed type-1 identity string: want 4 or 6 colon-separated fields, got %d", len(parts))
+	}
+
+	addressBytes, err := decodeHexField("address", parts[0], ztAddressHexLen/2)
+	if err != nil {
+		return nil, err
+	}
+	pubBytes, err := decodeHexField("public key", parts[2], ztPublicKeyHexLen/2)
+	if err != nil {
+		return nil, err
+	}
+	p384PubBytes, err := decodeHexField("P-384 public key", parts[3], p384PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	id := ZeroTierIdentity{idType: Type1}
+	for _, b := range addressBytes {
+		id.address <<= 8
+		id.address |= uint64(b)
+	}
+	copy(id.publicKey[:], pubBytes)
+	id.p384PublicKey = p384PubBytes
+
+	dig := computeZeroTierIdentityV1AddressHash(id.publicKey, id.p384PublicKey)
+	if dig[0] >= ztIdentityV1HashCashFirstByteLessThan {
+		return nil, &ProofOfWorkError{}
+	}
+	var derivedAddress uint64
+	for i := 43; i < 48; i++ {
+		derivedAddress <<= 8
+		derivedAddress |= uint64(dig[i])
+	}
+	if derivedAddress != id.address {
+		return nil, &AddressMismatchError{Encoded: id.address, Derived: derivedAddress}
+	}
+
+	if len(parts) == 6 {
+		privBytes, err := decodeHexField("private key", parts[4], ztPrivateKeyHexLen/2)
+		if err != nil {
+			return nil, err
+		}
+		p384PrivBytes, err := decodeHexField("P-384 private key", parts[5], p384PrivateKeySize)
+		if err != nil {
+			return nil, err
+		}
+		var priv [64]byte
+		copy(priv[:], privBytes)
+
+		if err := checkDualPairDerivesPublicKey(priv, id.publicKey); err != nil {
+			return nil, err
+		}
+
+		curve := elliptic.P384()
+		x, y := curve.ScalarBaseMult(p384PrivBytes)
+		if !bytes.Equal(elliptic.Marshal(curve, x, y), id.p384PublicKey) {
+			return nil, &KeyDerivationError{}
+		}
+
+		id.privateKey = &priv
+		id.p384PrivateKey = p384PrivBytes
+	}
+
+	return &id, nil
+}
+
+// checkDualPairDerivesPublicKey verifies that the curve25519 and ed25519 halves of
+// priv derive the corresponding halves of pub.
+func checkDualPairDerivesPublicKey(priv, pub [64]byte) error {
+	var derivedCurvePub [32]byte
+	var localCurvePriv [32]byte
+	copy(localCurvePriv[:], priv[0:32])
+	curve25519.ScalarBaseMult(&derivedCurvePub, &localCurvePriv)
+	if !bytes.Equal(derivedCurvePub[:], pub[0:32]) {
+		return &KeyDerivationError{}
+	}
+
+	edPriv := ed25519.NewKeyFromSeed(priv[32:64])
+	if !bytes.Equal(edPriv.Public().(ed25519.PublicKey), pub[32:64]) {
+		return &KeyDerivationError{}
+	}
+
+	return nil
+}
+
+func decodeHexField(field, s string, wantBytes int) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &HexDecodeError{Field: field, Err: err}
+	}
+	if len(b) != wantBytes {
+		return nil, &LengthError{Field: field, Got: len(b), Want: wantBytes}
+	}
+	return b, nil
+}
+
+// MarshalBinary encodes the identity into a compact non-hex form suitable for storing
+// identities in databases: a 1-byte IdentityType tag, 5 address bytes, 64 public key
+// bytes, the 97-byte P-384 public key if the identity is Type1, and a 1-byte private
+// key presence flag (1 or 0); when the flag is 1 it is followed by the 64-byte private
+// key (plus the 48-byte P-384 private key for Type1).
+func (id *ZeroTierIdentity) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, 1+5+64+p384PublicKeySize+1+64+p384PrivateKeySize)
+
+	addrBytes := addressBytes(id.address)
+	out = append(out, byte(id.idType))
+	out = append(out, addrBytes[:]...)
+	out = append(out, id.publicKey[:]...)
+	if id.idType == Type1 {
+		out = append(out, id.p384PublicKey...)
+	}
+
+	if id.privateKey != nil {
+		out = append(out, 1)
+		out = append(out, id.privateKey[:]...)
+		if id.idType == Type1 {
+			out = append(out, id.p384PrivateKey...)
+		}
+	} else {
+		out = append(out, 0)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes an identity encoded by MarshalBinary, dispatching on the
+// leading IdentityType tag and re-validating the proof-of-work and address-derivation
+// constraints exactly as ParseZeroTierIdentity does.
+func (id *ZeroTierIdentity) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return &LengthError{Field: "binary identity", Got: len(data), Want: 1}
+	}
+
+	switch IdentityType(data[0]) {
+	case Type0:
+		return id.unmarshalType0Binary(data[1:])
+	case Type1:
+		return id.unmarshalType1Binary(data[1:])
+	default:
+		return fmt.Errorf("ztidentity: unsupported identity type %d in binary identity", data[0])
+	}
+}
+
+func (id *ZeroTierIdentity) unmarshalType0Binary(data []byte) error {
+	if len(data) != 5+64+1 && len(data) != 5+64+1+64 {
+		return &LengthError{Field: "binary identity", Got: len(data), Want: 5 + 64 + 1}
+	}
+
+	var parsed ZeroTierIdentity
+	for _, b := range data[0:5] {
+		parsed.address <<= 8
+		parsed.address |= uint64(b)
+	}
+	copy(parsed.publicKey[:], data[5:69])
+
+	dig := computeZeroTierIdentityMemoryHardHash(parsed.publicKey[:])
+	if dig[0] >= ztIdentityHashCashFirstByteLessThan {
+		return &ProofOfWorkError{}
+	}
+	var derivedAddress uint64
+	for i := 59; i <= 63; i++ {
+		derivedAddress <<= 8
+		derivedAddress |= uint64(dig[i])
+	}
+	if derivedAddress != parsed.address {
+		return &AddressMismatchError{Encoded: parsed.address, Derived: derivedAddress}
+	}
+
+	hasPriv := data[69]
+	if hasPriv == 1 {
+		if len(data) != 5+64+1+64 {
+			return &LengthError{Field: "binary identity", Got: len(data), Want: 5 + 64 + 1 + 64}
+		}
+		var priv [64]byte
+		copy(priv[:], data[70:134])
+
+		if err := checkDualPairDerivesPublicKey(priv, parsed.publicKey); err != nil {
+			return err
+		}
+		parsed.privateKey = &priv
+	}
+
+	*id = parsed
+	return nil
+}
+
+func (id *ZeroTierIdentity) unmarshalType1Binary(data []byte) error {
+	const withoutPriv = 5 + 64 + p384PublicKeySize + 1
+	const withPriv = withoutPriv + 64 + p384PrivateKeySize
+	if len(data) != withoutPriv && len(data) != withPriv {
+		return &LengthError{Field: "binary identity", Got: len(data), Want: withoutPriv}
+	}
+
+	parsed := ZeroTierIdentity{idType: Type1}
+	for _, b := range data[0:5] {
+		parsed.address <<= 8
+		parsed.address |= uint64(b)
+	}
+	copy(parsed.publicKey[:], data[5:69])
+	parsed.p384PublicKey = append([]byte(nil), data[69:69+p384PublicKeySize]...)
+
+	dig := computeZeroTierIdentityV1AddressHash(parsed.publicKey, parsed.p384PublicKey)
+	if dig[0] >= ztIdentityV1HashCashFirstByteLessThan {
+		return &ProofOfWorkError{}
+	}
+	var derivedAddress uint64
+	for i := 43; i < 48; i++ {
+		derivedAddress <<= 8
+		derivedAddress |= uint64(dig[i])
+	}
+	if derivedAddress != parsed.address {
+		return &AddressMismatchError{Encoded: parsed.address, Derived: derivedAddress}
+	}
+
+	hasPrivOffset := 69 + p384PublicKeySize
+	hasPriv := data[hasPrivOffset]
+	if hasPriv == 1 {
+		if len(data) != withPriv {
+			return &LengthError{Field: "binary identity", Got: len(data), Want: withPriv}
+		}
+		privOffset := hasPrivOffset + 1
+		var priv [64]byte
+		copy(priv[:], data[privOffset:privOffset+64])
+		p384Priv := append([]byte(nil), data[privOffset+64:privOffset+64+p384PrivateKeySize]...)
+
+		if err := checkDualPairDerivesPublicKey(priv, parsed.publicKey); err != nil {
+			return err
+		}
+		curve := elliptic.P384()
+		x, y := curve.ScalarBaseMult(p384Priv)
+		if !bytes.Equal(elliptic.Marshal(curve, x, y), parsed.p384PublicKey) {
+			return &KeyDerivationError{}
+		}
+
+		parsed.privateKey = &priv
+		parsed.p384PrivateKey = p384Priv
+	}
+
+	*id = parsed
+	return nil
+}
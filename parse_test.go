@@ -0,0 +1,104 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import "testing"
+
+func TestParseZeroTierIdentityRoundTrip(t *testing.T) {
+	id := NewZeroTierIdentity()
+
+	parsed, err := ParseZeroTierIdentity(id.PrivateKeyString())
+	if err != nil {
+		t.Fatalf("ParseZeroTierIdentity: %v", err)
+	}
+	if parsed.IDString() != id.IDString() {
+		t.Fatalf("parsed address %s, want %s", parsed.IDString(), id.IDString())
+	}
+	if parsed.PrivateKeyString() != id.PrivateKeyString() {
+		t.Fatal("parsed private key string does not match original")
+	}
+
+	parsedPub, err := ParseZeroTierIdentity(id.PublicKeyString())
+	if err != nil {
+		t.Fatalf("ParseZeroTierIdentity (public only): %v", err)
+	}
+	if parsedPub.PrivateKey() != nil {
+		t.Fatal("expected nil private key when parsing a public-only identity string")
+	}
+}
+
+func TestParseZeroTierIdentityRejectsTamperedPublicKey(t *testing.T) {
+	id := NewZeroTierIdentity()
+	s := id.PublicKeyString()
+	tampered := s[:len(s)-1] + "0"
+	if tampered == s {
+		tampered = s[:len(s)-1] + "1"
+	}
+
+	if _, err := ParseZeroTierIdentity(tampered); err == nil {
+		t.Fatal("expected error parsing a tampered public key, got nil")
+	}
+}
+
+func TestMarshalUnmarshalBinaryRoundTrip(t *testing.T) {
+	id := NewZeroTierIdentity()
+
+	data, err := id.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	var decoded ZeroTierIdentity
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if decoded.PrivateKeyString() != id.PrivateKeyString() {
+		t.Fatal("round-tripped identity does not match original")
+	}
+}
+
+func TestMarshalUnmarshalBinaryRoundTripType1(t *testing.T) {
+	id := NewZeroTierIdentityV1()
+
+	data, err := id.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	var decoded ZeroTierIdentity
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if decoded.Type() != Type1 {
+		t.Fatalf("decoded.Type() = %v, want Type1", decoded.Type())
+	}
+	if decoded.PrivateKeyString() != id.PrivateKeyString() {
+		t.Fatal("round-tripped type-1 identity does not match original")
+	}
+}
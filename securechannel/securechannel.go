@@ -0,0 +1,244 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package securechannel wraps an io.ReadWriter (or net.Conn) into an authenticated,
+// framed transport using XChaCha20-Poly1305, keyed by the 32-byte session key produced
+// by ztidentity.Agree. Each frame is:
+//
+//	uint32 length || 24-byte nonce || ciphertext||tag
+//
+// where length counts the nonce and the ciphertext||tag that follow it. The send and
+// receive directions use independent subkeys derived from the session key via
+// HKDF-SHA512 with domain-separation labels, and a monotonically incremented 8-byte
+// counter is bound into each frame as additional authenticated data to detect reorder
+// and replay.
+package securechannel
+
+import (
+	secrand "crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// labelTx and labelRx are the HKDF info labels used to derive the two
+	// directional subkeys from a single 3DH session key. Which label is used
+	// for sending vs. receiving depends on the initiator flag so both ends
+	// derive the same pair of keys.
+	labelTx = "ztidentity-tx"
+	labelRx = "ztidentity-rx"
+
+	// KeySize is the size in bytes of a derived send or receive subkey.
+	KeySize = chacha20poly1305.KeySize
+
+	// NonceSize is the size in bytes of the random per-frame nonce.
+	NonceSize = chacha20poly1305.NonceSizeX
+
+	// counterSize is the size in bytes of the associated-data frame counter.
+	counterSize = 8
+
+	// maxFrameLength bounds the length prefix read off the wire so that a
+	// corrupt or malicious peer cannot force an unbounded allocation.
+	maxFrameLength = 1 << 20
+)
+
+// ErrFrameTooLarge is returned when a frame's length prefix exceeds maxFrameLength.
+var ErrFrameTooLarge = errors.New("securechannel: frame too large")
+
+// ErrShortFrame is returned when a frame is too small to contain a nonce and tag.
+var ErrShortFrame = errors.New("securechannel: frame too short")
+
+// DeriveKeys splits a 3DH session key into independent send/receive subkeys via
+// HKDF-SHA512. initiator must be the same flag passed to ztidentity.Agree so that
+// both ends of the channel agree on which label maps to which direction.
+func DeriveKeys(sessionKey []byte, initiator bool) (sendKey, recvKey []byte, err error) {
+	txLabel, rxLabel := labelTx, labelRx
+	if !initiator {
+		txLabel, rxLabel = labelRx, labelTx
+	}
+
+	sendKey, err = hkdfExpand(sessionKey, txLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	recvKey, err = hkdfExpand(sessionKey, rxLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sendKey, recvKey, nil
+}
+
+func hkdfExpand(sessionKey []byte, label string) ([]byte, error) {
+	key := make([]byte, KeySize)
+	r := hkdf.New(sha512.New, sessionKey, nil, []byte(label))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext under key with a fresh random nonce and the given frame
+// counter as associated data, and returns the wire-ready frame: nonce||ciphertext||tag.
+func Seal(key []byte, counter uint64, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(secrand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	var ad [counterSize]byte
+	binary.BigEndian.PutUint64(ad[:], counter)
+
+	out := make([]byte, NonceSize, NonceSize+len(plaintext)+aead.Overhead())
+	copy(out, nonce)
+	return aead.Seal(out, nonce, plaintext, ad[:]), nil
+}
+
+// Open authenticates and decrypts a frame body (nonce||ciphertext||tag, as produced by
+// Seal) under key, checking it against the expected frame counter as associated data.
+func Open(key []byte, counter uint64, frame []byte) ([]byte, error) {
+	if len(frame) < NonceSize+chacha20poly1305.Overhead {
+		return nil, ErrShortFrame
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var ad [counterSize]byte
+	binary.BigEndian.PutUint64(ad[:], counter)
+
+	nonce, ciphertext := frame[:NonceSize], frame[NonceSize:]
+	return aead.Open(nil, nonce, ciphertext, ad[:])
+}
+
+// Conn wraps an underlying io.ReadWriter (typically a net.Conn) with the framed AEAD
+// construction above, incrementing an independent counter per direction on each frame.
+type Conn struct {
+	rw         io.ReadWriter
+	sendKey    []byte
+	recvKey    []byte
+	sendCtr    uint64
+	recvCtr    uint64
+	recvBuffer []byte
+}
+
+// NewConn wraps rw using sendKey/recvKey as returned by DeriveKeys.
+func NewConn(rw io.ReadWriter, sendKey, recvKey []byte) *Conn {
+	return &Conn{rw: rw, sendKey: sendKey, recvKey: recvKey}
+}
+
+// Write encrypts and sends p as a single frame.
+func (c *Conn) Write(p []byte) (int, error) {
+	frame, err := Seal(c.sendKey, c.sendCtr, p)
+	if err != nil {
+		return 0, err
+	}
+	c.sendCtr++
+
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(frame)))
+	if _, err := c.rw.Write(lenPrefix[:]); err != nil {
+		return 0, err
+	}
+	if _, err := c.rw.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read returns the plaintext of the next frame, reading and verifying it from the
+// underlying transport if no buffered plaintext remains from a previous frame.
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(c.recvBuffer) == 0 {
+		plaintext, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.recvBuffer = plaintext
+	}
+
+	n := copy(p, c.recvBuffer)
+	c.recvBuffer = c.recvBuffer[n:]
+	return n, nil
+}
+
+func (c *Conn) readFrame() ([]byte, error) {
+	var lenPrefix [4]byte
+	if _, err := io.ReadFull(c.rw, lenPrefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lenPrefix[:])
+	if length > maxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, frame); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := Open(c.recvKey, c.recvCtr, frame)
+	if err != nil {
+		return nil, err
+	}
+	c.recvCtr++
+	return plaintext, nil
+}
+
+// netConn adapts Conn to the net.Conn interface by delegating everything but
+// Read/Write to an underlying net.Conn.
+type netConn struct {
+	*Conn
+	underlying net.Conn
+}
+
+// NewNetConn wraps conn using sendKey/recvKey as returned by DeriveKeys, returning a
+// net.Conn whose Read/Write are authenticated and framed as described above.
+func NewNetConn(conn net.Conn, sendKey, recvKey []byte) net.Conn {
+	return &netConn{Conn: NewConn(conn, sendKey, recvKey), underlying: conn}
+}
+
+func (c *netConn) Close() error                       { return c.underlying.Close() }
+func (c *netConn) LocalAddr() net.Addr                { return c.underlying.LocalAddr() }
+func (c *netConn) RemoteAddr() net.Addr               { return c.underlying.RemoteAddr() }
+func (c *netConn) SetDeadline(t time.Time) error      { return c.underlying.SetDeadline(t) }
+func (c *netConn) SetReadDeadline(t time.Time) error  { return c.underlying.SetReadDeadline(t) }
+func (c *netConn) SetWriteDeadline(t time.Time) error { return c.underlying.SetWriteDeadline(t) }
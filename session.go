@@ -0,0 +1,125 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrNoPrivateKey is returned by operations that require a private key when the identity holds only a public key.
+var ErrNoPrivateKey = errors.New("ztidentity: identity has no private key")
+
+// Sign signs msg with the identity's ed25519 key and returns a 96-byte signature.
+// The first 64 bytes are a standard ed25519 signature over SHA-512(msg); the last
+// 32 bytes are the first half of that SHA-512 digest, following ZeroTier's convention
+// of making the signature self-describing so that Verify does not need msg and sig
+// to be re-hashed through an external channel to be checked for consistency.
+func (id *ZeroTierIdentity) Sign(msg []byte) ([96]byte, error) {
+	var sig [96]byte
+	if id.privateKey == nil {
+		return sig, ErrNoPrivateKey
+	}
+	digest := sha512.Sum512(msg)
+	edPriv := ed25519.NewKeyFromSeed(id.privateKey[32:64])
+	rawSig := ed25519.Sign(edPriv, digest[:])
+	copy(sig[0:64], rawSig)
+	copy(sig[64:96], digest[0:32])
+	return sig, nil
+}
+
+// Verify checks a 96-byte signature produced by Sign against msg and the identity's ed25519 public key.
+func (id *ZeroTierIdentity) Verify(msg, sig []byte) bool {
+	if len(sig) != 96 {
+		return false
+	}
+	digest := sha512.Sum512(msg)
+	if subtle.ConstantTimeCompare(digest[0:32], sig[64:96]) != 1 {
+		return false
+	}
+	edPub := ed25519.PublicKey(id.publicKey[32:64])
+	return ed25519.Verify(edPub, digest[:], sig[0:64])
+}
+
+// Agree performs a Triple Diffie-Hellman (3DH) key agreement between local and a remote
+// party, analogous to Tapir's Perform3DH. It combines three curve25519 ECDH terms:
+//
+//	DH1 = ECDH(local long-term priv, remote ephemeral pub)
+//	DH2 = ECDH(local ephemeral priv, remote long-term pub)
+//	DH3 = ECDH(local ephemeral priv, remote ephemeral pub)
+//
+// Each term is computed with curve25519.X25519 rather than the deprecated ScalarMult,
+// so a remote public key that is the identity element or otherwise low-order (and
+// would otherwise silently force an all-zero, attacker-predictable DH output) makes
+// Agree fail instead of handing back a degenerate session key.
+//
+// DH1 and DH2 are ordered by the initiator flag so that both sides compute the same
+// byte stream regardless of which side is calling Agree, then DH1||DH2||DH3 is hashed
+// with SHA-512 to derive a 32-byte session key. The identity's ed25519 key is not used
+// here; callers are expected to Sign the ephemeral public key passed to the peer so
+// that it can be authenticated against the long-term identity before Agree is called.
+func Agree(local *ZeroTierIdentity, localEphemeralPriv [32]byte, remoteLongTermPub, remoteEphemeralPub [32]byte, initiator bool) ([]byte, error) {
+	if local.privateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
+
+	var localLongTermPriv [32]byte
+	copy(localLongTermPriv[:], local.privateKey[0:32])
+
+	dhLongEph, err := curve25519.X25519(localLongTermPriv[:], remoteEphemeralPub[:])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: Agree: %w", err)
+	}
+	dhEphLong, err := curve25519.X25519(localEphemeralPriv[:], remoteLongTermPub[:])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: Agree: %w", err)
+	}
+	dhEphEph, err := curve25519.X25519(localEphemeralPriv[:], remoteEphemeralPub[:])
+	if err != nil {
+		return nil, fmt.Errorf("ztidentity: Agree: %w", err)
+	}
+
+	var combined [96]byte
+	if initiator {
+		copy(combined[0:32], dhLongEph)
+		copy(combined[32:64], dhEphLong)
+	} else {
+		copy(combined[0:32], dhEphLong)
+		copy(combined[32:64], dhLongEph)
+	}
+	copy(combined[64:96], dhEphEph)
+
+	sessionKey := sha512.Sum512(combined[:])
+	return sessionKey[0:32], nil
+}
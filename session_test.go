@@ -0,0 +1,137 @@
+// Copyright (c) 2021, ZeroTier, Inc.
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ztidentity
+
+import (
+	"bytes"
+	secrand "crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	id := NewZeroTierIdentity()
+	msg := []byte("hello ztidentity")
+
+	sig, err := id.Sign(msg)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !id.Verify(msg, sig[:]) {
+		t.Fatal("Verify rejected a genuine signature")
+	}
+}
+
+func TestVerifyRejectsTamperedMessage(t *testing.T) {
+	id := NewZeroTierIdentity()
+	msg := []byte("hello ztidentity")
+
+	sig, err := id.Sign(msg)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if id.Verify([]byte("goodbye ztidentity"), sig[:]) {
+		t.Fatal("Verify accepted a signature over a different message")
+	}
+}
+
+func TestVerifyRejectsTamperedSignature(t *testing.T) {
+	id := NewZeroTierIdentity()
+	msg := []byte("hello ztidentity")
+
+	sig, err := id.Sign(msg)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	sig[0] ^= 0xff
+	if id.Verify(msg, sig[:]) {
+		t.Fatal("Verify accepted a tampered signature")
+	}
+}
+
+func TestSignRequiresPrivateKey(t *testing.T) {
+	pubOnly := NewZeroTierIdentity()
+	pubOnly.privateKey = nil
+
+	if _, err := pubOnly.Sign([]byte("msg")); err != ErrNoPrivateKey {
+		t.Fatalf("Sign error = %v, want ErrNoPrivateKey", err)
+	}
+}
+
+func randomCurve25519Priv(t *testing.T) [32]byte {
+	t.Helper()
+	var priv [32]byte
+	if _, err := io.ReadFull(secrand.Reader, priv[:]); err != nil {
+		t.Fatalf("reading random bytes: %v", err)
+	}
+	return priv
+}
+
+func TestAgreeSymmetricBetweenInitiatorAndResponder(t *testing.T) {
+	alice := NewZeroTierIdentity()
+	bob := NewZeroTierIdentity()
+
+	aliceEphPriv := randomCurve25519Priv(t)
+	bobEphPriv := randomCurve25519Priv(t)
+
+	var aliceEphPub, bobEphPub [32]byte
+	curve25519.ScalarBaseMult(&aliceEphPub, &aliceEphPriv)
+	curve25519.ScalarBaseMult(&bobEphPub, &bobEphPriv)
+
+	aliceFullPub := alice.PublicKey()
+	bobFullPub := bob.PublicKey()
+	var aliceLongPub, bobLongPub [32]byte
+	copy(aliceLongPub[:], aliceFullPub[0:32])
+	copy(bobLongPub[:], bobFullPub[0:32])
+
+	aliceKey, err := Agree(&alice, aliceEphPriv, bobLongPub, bobEphPub, true)
+	if err != nil {
+		t.Fatalf("Agree (initiator): %v", err)
+	}
+	bobKey, err := Agree(&bob, bobEphPriv, aliceLongPub, aliceEphPub, false)
+	if err != nil {
+		t.Fatalf("Agree (responder): %v", err)
+	}
+
+	if !bytes.Equal(aliceKey, bobKey) {
+		t.Fatalf("session keys differ: initiator=%x responder=%x", aliceKey, bobKey)
+	}
+}
+
+func TestAgreeRejectsLowOrderRemotePoint(t *testing.T) {
+	id := NewZeroTierIdentity()
+	ephPriv := randomCurve25519Priv(t)
+
+	var zero [32]byte // the identity element, a canonical low-order point
+	if _, err := Agree(&id, ephPriv, zero, zero, true); err == nil {
+		t.Fatal("expected Agree to reject an all-zero (low-order) remote point")
+	}
+}